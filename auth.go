@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// OIDC/OAuth2 configuration for browser-based submissions. This runs
+// alongside the static bearer tokens in AllowedAuthorizationTokens so
+// existing script/kiosk clients keep working while students authenticate
+// with their institution's OIDC provider.
+type OIDCConf struct {
+	Enabled   bool
+	IssuerURL string
+	ClientID  string
+
+	// AllowedAudiences restricts accepted tokens to these `aud` values. If
+	// empty, the token's `aud` must include ClientID instead (useful when
+	// ClientID is itself the only intended audience).
+	AllowedAudiences []string
+
+	// AllowedHostedDomains and AllowedEmailDomains restrict who may submit,
+	// checked against the `hd` claim (Google Workspace) and the domain
+	// part of the `email` claim respectively. Either may be left empty to
+	// skip that check.
+	AllowedHostedDomains []string
+	AllowedEmailDomains  []string
+
+	// SurfIdClaim is the claim used to populate Request.SurfId, eg. "sub"
+	// or an institution-specific claim such as "surf_id". Defaults to "sub".
+	SurfIdClaim string
+
+	// JWKSRefreshInterval controls how often we re-fetch the provider's
+	// discovery document and JWKS, on top of go-oidc's own verify-time
+	// refresh on unknown key IDs. Defaults to 1h. This catches a rotated
+	// key even if no token using it has been seen yet.
+	JWKSRefreshInterval time.Duration
+}
+
+// oidcVerifier validates ID/access tokens against the configured issuer.
+// go-oidc fetches and caches the issuer's JWKS internally and refreshes it
+// as key IDs are rotated in, so we don't manage that cache ourselves. It's
+// read from every /submit request but reassigned by runOIDCRefresher's
+// background goroutine, so it's stored behind an atomic.Pointer rather
+// than a plain var.
+var oidcVerifier atomic.Pointer[oidc.IDTokenVerifier]
+
+// initOIDC sets up oidcVerifier if OIDC auth is enabled in the config. It
+// is a no-op (oidcVerifier stays nil) when conf.OIDC.Enabled is false.
+func initOIDC() error {
+	if !conf.OIDC.Enabled {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), conf.OIDC.IssuerURL)
+	if err != nil {
+		return err
+	}
+
+	oidcVerifier.Store(provider.Verifier(&oidc.Config{
+		ClientID:          conf.OIDC.ClientID,
+		SkipClientIDCheck: len(conf.OIDC.AllowedAudiences) > 0,
+	}))
+	return nil
+}
+
+// runOIDCRefresher periodically re-initializes oidcVerifier so a rotated
+// signing key is picked up even before a token using it is first seen.
+// It never returns.
+func runOIDCRefresher(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := initOIDC(); err != nil {
+			log.Printf("Failed to refresh OIDC provider config: %s", err)
+		}
+	}
+}
+
+// checkBearerAuth verifies the `Bearer` JWT on r, if present, and returns
+// the Request it authorizes. ok is false (and a response has already been
+// written to w) if a Bearer token was present but invalid; present is
+// false if there was no Bearer token at all, so the caller can fall back
+// to the static-token flow.
+func checkBearerAuth(w http.ResponseWriter, r *http.Request) (request Request, present bool, ok bool) {
+	auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(auth) != 2 || auth[0] != "Bearer" {
+		return Request{}, false, false
+	}
+	present = true
+
+	verifier := oidcVerifier.Load()
+	if verifier == nil {
+		http.Error(w, "OIDC authentication is not enabled", http.StatusUnauthorized)
+		return Request{}, present, false
+	}
+
+	idToken, err := verifier.Verify(r.Context(), auth[1])
+	if err != nil {
+		log.Printf("Rejected bearer token: %s", err)
+		http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+		return Request{}, present, false
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		log.Printf("Could not parse claims from bearer token: %s", err)
+		http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+		return Request{}, present, false
+	}
+
+	if len(conf.OIDC.AllowedAudiences) > 0 && !audienceAllowed(idToken.Audience) {
+		http.Error(w, "Token audience not allowed", http.StatusForbidden)
+		return Request{}, present, false
+	}
+
+	email, _ := claims["email"].(string)
+	hd, _ := claims["hd"].(string)
+
+	if !hostedDomainAllowed(hd) || !emailDomainAllowed(email) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return Request{}, present, false
+	}
+
+	name, _ := claims["name"].(string)
+	surfIdClaim := conf.OIDC.SurfIdClaim
+	if surfIdClaim == "" {
+		surfIdClaim = "sub"
+	}
+	surfId, _ := claims[surfIdClaim].(string)
+	if surfIdClaim == "sub" && surfId == "" {
+		surfId = idToken.Subject
+	}
+
+	return Request{
+		Name:   name,
+		EMail:  email,
+		SurfId: surfId,
+	}, present, true
+}
+
+func audienceAllowed(audiences []string) bool {
+	for _, aud := range audiences {
+		for _, allowed := range conf.OIDC.AllowedAudiences {
+			if aud == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hostedDomainAllowed(hd string) bool {
+	if len(conf.OIDC.AllowedHostedDomains) == 0 {
+		return true
+	}
+	for _, allowed := range conf.OIDC.AllowedHostedDomains {
+		if hd == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func emailDomainAllowed(email string) bool {
+	if len(conf.OIDC.AllowedEmailDomains) == 0 {
+		return true
+	}
+	parts := strings.SplitN(email, "@", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	for _, allowed := range conf.OIDC.AllowedEmailDomains {
+		if parts[1] == allowed {
+			return true
+		}
+	}
+	return false
+}
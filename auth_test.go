@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestAudienceAllowed(t *testing.T) {
+	conf.OIDC.AllowedAudiences = []string{"course-app"}
+	defer func() { conf.OIDC.AllowedAudiences = nil }()
+
+	if !audienceAllowed([]string{"other", "course-app"}) {
+		t.Error("expected an allowed audience among several to pass")
+	}
+	if audienceAllowed([]string{"other"}) {
+		t.Error("expected a disallowed audience to be rejected")
+	}
+	if audienceAllowed(nil) {
+		t.Error("expected no audiences to be rejected")
+	}
+}
+
+func TestHostedDomainAllowed(t *testing.T) {
+	conf.OIDC.AllowedHostedDomains = nil
+	if !hostedDomainAllowed("anything.example") {
+		t.Error("expected an empty allowlist to accept any hd")
+	}
+
+	conf.OIDC.AllowedHostedDomains = []string{"uni.example"}
+	defer func() { conf.OIDC.AllowedHostedDomains = nil }()
+
+	if !hostedDomainAllowed("uni.example") {
+		t.Error("expected the allowed hd to pass")
+	}
+	if hostedDomainAllowed("evil.example") {
+		t.Error("expected a disallowed hd to be rejected")
+	}
+}
+
+func TestEmailDomainAllowed(t *testing.T) {
+	conf.OIDC.AllowedEmailDomains = nil
+	if !emailDomainAllowed("student@anything.example") {
+		t.Error("expected an empty allowlist to accept any email domain")
+	}
+
+	conf.OIDC.AllowedEmailDomains = []string{"uni.example"}
+	defer func() { conf.OIDC.AllowedEmailDomains = nil }()
+
+	if !emailDomainAllowed("student@uni.example") {
+		t.Error("expected the allowed email domain to pass")
+	}
+	if emailDomainAllowed("student@evil.example") {
+		t.Error("expected a disallowed email domain to be rejected")
+	}
+	if emailDomainAllowed("not-an-email") {
+		t.Error("expected a malformed email to be rejected")
+	}
+}
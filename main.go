@@ -30,8 +30,34 @@ var (
 // Configuration
 type Conf struct {
 	AllowedAuthorizationTokens []string
+	AllowedReadTokens          []string
+	AllowedSessionAdminTokens  []string
 	BindAddr                   string // address to bind to, eg. ":8080"
 	DbPath                     string
+	SMTP                       SMTPConf
+	OIDC                       OIDCConf
+	Templates                  TemplatesConf
+}
+
+// SMTP relay configuration for confirmation mails. The mails' templates
+// are configured separately, in TemplatesConf.
+type SMTPConf struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// StartTLS records whether the relay is expected to offer STARTTLS;
+	// gomail negotiates it automatically when the server advertises it,
+	// so this only documents the deployment: the code does not read it and
+	// does not check whether the relay uses STARTTLS. Set UseTLS instead to
+	// connect over implicit TLS (eg. port 465).
+	StartTLS bool
+	UseTLS   bool
+	From     string
+
+	// OutboxInterval is how often the background worker scans for due
+	// retries. Defaults to 30s.
+	OutboxInterval time.Duration
 }
 
 // Data sent along with the POST request
@@ -39,15 +65,25 @@ type Request struct {
 	Name   string
 	SurfId string
 	EMail  string
+	// Lang selects the confirmation email's language, eg. "en" or "nl".
+	// Falls back to Templates.DefaultLang if empty or unknown.
+	Lang string
+	// SessionToken is the signed, short-lived token shown as a QR code by
+	// the lecturer, proving the submission happened during a real session.
+	SessionToken string `json:"session_token"`
 }
 
 // Records of attendence
 type Record struct {
-	ID     uint `gorm:"primary_key"`
-	When   time.Time
-	Name   string
-	SurfId string
-	EMail  string
+	ID uint `gorm:"primary_key"`
+	// SessionID and SurfId together are unique: a student can only
+	// register attendance once per session, even across retries.
+	SessionID uint `gorm:"unique_index:idx_session_surfid"`
+	When      time.Time
+	Name      string
+	SurfId    string `gorm:"unique_index:idx_session_surfid"`
+	EMail     string
+	Lang      string
 }
 
 func main() {
@@ -55,6 +91,15 @@ func main() {
 
 	// Configuration defaults
 	conf.DbPath = "db.sqlite3"
+	conf.SMTP.Host = "localhost"
+	conf.SMTP.Port = 25
+	conf.SMTP.From = "Privacy Seminar <no-reply@metrics.privacybydesign.foundation>"
+	conf.SMTP.OutboxInterval = 30 * time.Second
+	conf.OIDC.SurfIdClaim = "sub"
+	conf.OIDC.JWKSRefreshInterval = time.Hour
+	conf.Templates.DefaultLang = "en"
+	conf.Templates.CourseName = "Privacy and Identity"
+	conf.Templates.Instructors = []string{"Koning and Jacobs"}
 
 	// parse commandline
 	flag.StringVar(&confPath, "config", "config.yaml",
@@ -91,15 +136,35 @@ func main() {
 	log.Println(" ok")
 
 	log.Println("Auto-migration (if necessary) ...")
-	db.AutoMigrate(Record{})
+	db.AutoMigrate(Record{}, PendingEmail{}, Session{})
 	log.Println(" ok")
 
 	// Set up mailer
-	mailer = gomail.NewDialer("localhost", 25, "", "")
+	mailer = gomail.NewDialer(conf.SMTP.Host, conf.SMTP.Port,
+		conf.SMTP.Username, conf.SMTP.Password)
+	mailer.SSL = conf.SMTP.UseTLS
+
+	// Drain the outbox in the background so transient MTA failures don't
+	// drop confirmations; failed sends are retried with backoff.
+	go runOutboxWorker(conf.SMTP.OutboxInterval)
+
+	// Set up OIDC, if configured, so /submit accepts Bearer tokens from
+	// students' institution in addition to the static tokens below.
+	if err := initOIDC(); err != nil {
+		log.Fatalf("Could not set up OIDC: %s", err)
+	}
+	if conf.OIDC.Enabled {
+		go runOIDCRefresher(conf.OIDC.JWKSRefreshInterval)
+	}
 
 	// set up HTTP server
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", submitHandler)
+	mux.HandleFunc("/records", recordsJSONHandler)
+	mux.HandleFunc("/records.csv", recordsCSVHandler)
+	mux.HandleFunc("/stats", statsHandler)
+	mux.HandleFunc("/sessions", createSessionHandler)
+	mux.HandleFunc("/sessions/", rotateSessionHandler)
 	corsMiddleWare := cors.New(cors.Options{
 		AllowCredentials: true,
 		AllowedHeaders:   []string{"Authorization"},
@@ -111,13 +176,25 @@ func main() {
 		log.Println("Warning: 'allowedauthorizationtokens' is empty!")
 		log.Println("           --- I will accept data from anyone!")
 	}
+	if len(conf.AllowedReadTokens) == 0 {
+		log.Println("Warning: 'allowedreadtokens' is empty!")
+		log.Println("           --- I will let anyone read the attendance records!")
+	}
+	if len(conf.AllowedSessionAdminTokens) == 0 {
+		log.Println("Warning: 'allowedsessionadmintokens' is empty!")
+		log.Println("           --- I will let anyone create and rotate sessions!")
+	}
 
 	log.Fatal(http.ListenAndServe(conf.BindAddr, corsMiddleWare.Handler(mux)))
 }
 
-// Check if the right authorization header is present
-func checkAuthorization(w http.ResponseWriter, r *http.Request) bool {
-	if len(conf.AllowedAuthorizationTokens) == 0 {
+// checkAuthorization checks the request's Basic Authorization header
+// against tokens, so /submit, /records and /sessions can each be scoped
+// to their own set of credentials (conf.AllowedAuthorizationTokens,
+// conf.AllowedReadTokens and conf.AllowedSessionAdminTokens respectively).
+// An empty tokens list means the endpoint is unauthenticated.
+func checkAuthorization(w http.ResponseWriter, r *http.Request, tokens []string) bool {
+	if len(tokens) == 0 {
 		return true
 	}
 
@@ -128,7 +205,7 @@ func checkAuthorization(w http.ResponseWriter, r *http.Request) bool {
 	}
 
 	token := []byte(auth[1])
-	for _, okToken := range conf.AllowedAuthorizationTokens {
+	for _, okToken := range tokens {
 		if subtle.ConstantTimeCompare(token, []byte(okToken)) == 1 {
 			return true
 		}
@@ -141,44 +218,69 @@ func checkAuthorization(w http.ResponseWriter, r *http.Request) bool {
 func submitHandler(w http.ResponseWriter, r *http.Request) {
 	var request Request
 
-	if !checkAuthorization(w, r) {
+	// A Bearer token means the student authenticated with the OIDC
+	// provider in their browser; trust the verified claims and ignore
+	// whatever the POST body claims about Name/SurfId/EMail. Anything
+	// else falls back to the static shared-token scheme so existing
+	// clients keep working. Either way the POST body still carries the
+	// session_token proving physical presence.
+	oidcRequest, isBearer, oidcOk := checkBearerAuth(w, r)
+	if isBearer && !oidcOk {
+		return
+	}
+	if !isBearer && !checkAuthorization(w, r, conf.AllowedAuthorizationTokens) {
 		return
 	}
 
-	err := json.Unmarshal([]byte(r.FormValue("request")), &request)
-	if err != nil {
+	if err := json.Unmarshal([]byte(r.FormValue("request")), &request); err != nil {
 		http.Error(w, fmt.Sprintf(
 			"Missing or malformed request form field: %s", err), 400)
 		return
 	}
+	if isBearer {
+		request.Name = oidcRequest.Name
+		request.SurfId = oidcRequest.SurfId
+		request.EMail = oidcRequest.EMail
+	}
 
-	record := Record{
-		When:   time.Now(),
-		Name:   request.Name,
-		SurfId: request.SurfId,
-		EMail:  request.EMail,
+	session, err := verifySessionToken(request.SessionToken)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid session_token: %s", err), http.StatusUnauthorized)
+		return
 	}
 
-	if err := db.Create(&record).Error; err != nil {
-		log.Printf("Failed to store attendance record: %s", err)
+	record := Record{
+		When:      time.Now(),
+		Name:      request.Name,
+		SurfId:    request.SurfId,
+		EMail:     request.EMail,
+		Lang:      request.Lang,
+		SessionID: session.ID,
 	}
 
-	m := gomail.NewMessage()
-	m.SetHeader("From", "Privacy Seminar <no-reply@metrics.privacybydesign.foundation>")
-	m.SetHeader("To", record.EMail)
-	m.SetHeader("Subject", "Your presence at Privacy and Identity")
-	m.SetBody("text/plain",
-		fmt.Sprintf(("Dear student %s,\n"+
-			"\n"+
-			"This email confirms that your presence at the course\n"+
-			"Privacy and Identity has been registered at: %s\n"+
-			"\n"+
-			"With best regards,\n"+
-			"Koning and Jacobs\n"),
-			record.Name, record.When))
-	go func(m *gomail.Message) {
-		if err := mailer.DialAndSend(m); err != nil {
-			log.Printf("Failed to so end e-mail: %s", err)
+	// Persist the record and queue its confirmation mail atomically: if
+	// either write fails, neither is kept, so we never register
+	// attendance without a durable confirmation to send.
+	tx := db.Begin()
+	if err := tx.Create(&record).Error; err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			http.Error(w, "Attendance for this session was already registered", http.StatusConflict)
+			return
 		}
-	}(m)
+		log.Printf("Failed to store attendance record: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := enqueueConfirmationEmail(tx, record); err != nil {
+		tx.Rollback()
+		log.Printf("Failed to queue confirmation e-mail: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		log.Printf("Failed to commit attendance record: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gomail.v2"
+)
+
+// A PendingEmail is a queued confirmation mail that still needs to be
+// (re)delivered. Rows are created in the same transaction as the Record
+// they belong to, so a crash between the DB write and the SMTP send can
+// never silently lose a confirmation: the worker will pick it up again.
+type PendingEmail struct {
+	ID       uint `gorm:"primary_key"`
+	RecordID uint
+	To       string
+	Subject  string
+	Body     string
+	// HTMLBody is sent as a text/html alternative part alongside Body when
+	// a language has an HTML template; empty for plain-text-only mail.
+	HTMLBody    string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Sent        bool
+}
+
+// enqueueConfirmationEmail renders and stores a PendingEmail for the given
+// record. It must be called within the same transaction that creates the
+// record, so either both are persisted or neither is.
+func enqueueConfirmationEmail(tx *gorm.DB, record Record) error {
+	subject, textBody, htmlBody, err := renderConfirmation(record)
+	if err != nil {
+		return err
+	}
+
+	pending := PendingEmail{
+		RecordID:    record.ID,
+		To:          record.EMail,
+		Subject:     subject,
+		Body:        textBody,
+		HTMLBody:    htmlBody,
+		NextAttempt: time.Now(),
+	}
+	return tx.Create(&pending).Error
+}
+
+// outboxBackoff returns how long to wait before retrying after the given
+// number of failed attempts, with a small jitter to avoid thundering-herd
+// retries against the MTA.
+func outboxBackoff(attempts int) time.Duration {
+	base := time.Second * 5
+	max := time.Minute * 30
+
+	d := base << uint(attempts)
+	if d <= 0 || d > max {
+		d = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(d) / 4))
+	return d + jitter
+}
+
+// runOutboxWorker periodically drains the outbox, retrying failed sends
+// with exponential backoff. It never returns.
+func runOutboxWorker(interval time.Duration) {
+	for {
+		drainOutboxOnce()
+		time.Sleep(interval)
+	}
+}
+
+// drainOutboxOnce sends every due, unsent PendingEmail once.
+func drainOutboxOnce() {
+	var pending []PendingEmail
+	if err := db.Where("sent = ? AND next_attempt <= ?", false, time.Now()).
+		Find(&pending).Error; err != nil {
+		log.Printf("Failed to query outbox: %s", err)
+		return
+	}
+
+	for _, p := range pending {
+		sendOutboxEmail(p)
+	}
+}
+
+// sendOutboxEmail attempts to deliver a single pending email, marking it
+// sent on success or rescheduling it with backoff on failure.
+func sendOutboxEmail(p PendingEmail) {
+	m := gomail.NewMessage()
+	m.SetHeader("From", conf.SMTP.From)
+	m.SetHeader("To", p.To)
+	m.SetHeader("Subject", p.Subject)
+	m.SetBody("text/plain", p.Body)
+	if p.HTMLBody != "" {
+		m.AddAlternative("text/html", p.HTMLBody)
+	}
+
+	if err := mailer.DialAndSend(m); err != nil {
+		p.Attempts++
+		p.LastError = err.Error()
+		p.NextAttempt = time.Now().Add(outboxBackoff(p.Attempts))
+		log.Printf("Failed to send outbox email %d (attempt %d): %s", p.ID, p.Attempts, err)
+		db.Save(&p)
+		return
+	}
+
+	p.Sent = true
+	p.LastError = ""
+	db.Save(&p)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRecordsLimit and maxRecordsLimit bound the page size for
+// GET /records and /records.csv.
+const (
+	defaultRecordsLimit = 100
+	maxRecordsLimit     = 1000
+)
+
+// queryRecords applies the from/to/surfid/limit/after_id query params
+// shared by the JSON and CSV endpoints and returns the matching records.
+func queryRecords(r *http.Request) ([]Record, error) {
+	q := r.URL.Query()
+
+	query := db.Order("id asc")
+
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("\"when\" >= ?", t)
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("\"when\" <= ?", t)
+	}
+	if surfId := q.Get("surfid"); surfId != "" {
+		query = query.Where("surf_id = ?", surfId)
+	}
+
+	limit := defaultRecordsLimit
+	if l := q.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil {
+			return nil, err
+		}
+		limit = parsed
+	}
+	if limit <= 0 || limit > maxRecordsLimit {
+		limit = maxRecordsLimit
+	}
+	query = query.Limit(limit)
+
+	if afterId := q.Get("after_id"); afterId != "" {
+		parsed, err := strconv.ParseUint(afterId, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("id > ?", parsed)
+	}
+
+	var records []Record
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// recordsJSONHandler handles GET /records: authenticated, paginated JSON
+// listing of attendance records.
+func recordsJSONHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAuthorization(w, r, conf.AllowedReadTokens) {
+		return
+	}
+
+	records, err := queryRecords(r)
+	if err != nil {
+		http.Error(w, "Bad query parameters", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		log.Printf("Failed to encode records as JSON: %s", err)
+	}
+}
+
+// recordsCSVHandler handles GET /records.csv: the same query as
+// recordsJSONHandler, streamed as RFC 4180 CSV.
+func recordsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAuthorization(w, r, conf.AllowedReadTokens) {
+		return
+	}
+
+	records, err := queryRecords(r)
+	if err != nil {
+		http.Error(w, "Bad query parameters", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	cw.Write([]string{"id", "session_id", "when", "name", "surfid", "email", "lang"})
+	for _, record := range records {
+		cw.Write([]string{
+			strconv.FormatUint(uint64(record.ID), 10),
+			strconv.FormatUint(uint64(record.SessionID), 10),
+			record.When.Format(time.RFC3339),
+			record.Name,
+			record.SurfId,
+			record.EMail,
+			record.Lang,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Printf("Failed to write records CSV: %s", err)
+	}
+}
+
+// sessionCount is one row of the GET /stats response: the number of
+// attendance records for a single session on a single calendar day.
+type sessionCount struct {
+	Day       string `json:"day"`
+	SessionID uint   `json:"session_id"`
+	Count     int    `json:"count"`
+}
+
+// statsHandler handles GET /stats: per-session attendance counts grouped
+// by day, so lecturers can see turnout per lecture without querying the
+// DB directly.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAuthorization(w, r, conf.AllowedReadTokens) {
+		return
+	}
+
+	var counts []sessionCount
+	err := db.Model(&Record{}).
+		Select("strftime('%Y-%m-%d', \"when\") as day, session_id, count(*) as count").
+		Group("day, session_id").
+		Order("day asc, session_id asc").
+		Scan(&counts).Error
+	if err != nil {
+		log.Printf("Failed to compute stats: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(counts); err != nil {
+		log.Printf("Failed to encode stats as JSON: %s", err)
+	}
+}
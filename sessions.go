@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSessionTokenValidity is how long every freshly (re)generated
+// session_token is valid for; requests cannot ask for another validity.
+// Short-lived on purpose: the QR code on the projector is expected to
+// rotate well before this expires.
+const defaultSessionTokenValidity = 30 * time.Second
+
+// A Session is a single lecture. Its HMACKey signs the session_tokens
+// shown as a rotating QR code, binding a /submit to physical presence
+// during [StartsAt, EndsAt].
+type Session struct {
+	ID       uint `gorm:"primary_key"`
+	Title    string
+	StartsAt time.Time
+	EndsAt   time.Time
+	HMACKey  []byte
+}
+
+// newSessionHMACKey generates a random per-session signing key.
+func newSessionHMACKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// signSessionToken produces a session_token for session valid until
+// expiresAt: base64url(sessionID|expiry|nonce).base64url(hmac-sha256).
+func signSessionToken(session Session, expiresAt time.Time) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload := fmt.Sprintf("%d|%d|%s", session.ID, expiresAt.Unix(),
+		base64.RawURLEncoding.EncodeToString(nonce))
+
+	mac := hmac.New(sha256.New, session.HMACKey)
+	mac.Write([]byte(payload))
+	sig := mac.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// verifySessionToken checks a session_token's signature and expiry and
+// returns the Session it was issued for.
+func verifySessionToken(token string) (Session, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return Session{}, fmt.Errorf("malformed session token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Session{}, fmt.Errorf("malformed session token: %s", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Session{}, fmt.Errorf("malformed session token: %s", err)
+	}
+
+	fields := strings.SplitN(string(payload), "|", 3)
+	if len(fields) != 3 {
+		return Session{}, fmt.Errorf("malformed session token")
+	}
+	sessionId, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return Session{}, fmt.Errorf("malformed session token: %s", err)
+	}
+	expiry, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Session{}, fmt.Errorf("malformed session token: %s", err)
+	}
+
+	var session Session
+	if err := db.First(&session, uint(sessionId)).Error; err != nil {
+		return Session{}, fmt.Errorf("unknown session")
+	}
+
+	mac := hmac.New(sha256.New, session.HMACKey)
+	mac.Write(payload)
+	expectedSig := mac.Sum(nil)
+	if !hmac.Equal(sig, expectedSig) {
+		return Session{}, fmt.Errorf("invalid session token signature")
+	}
+
+	if time.Now().Unix() > expiry {
+		return Session{}, fmt.Errorf("session token expired")
+	}
+
+	now := time.Now()
+	if !session.StartsAt.IsZero() && now.Before(session.StartsAt) {
+		return Session{}, fmt.Errorf("session has not started yet")
+	}
+	if !session.EndsAt.IsZero() && now.After(session.EndsAt) {
+		return Session{}, fmt.Errorf("session has ended")
+	}
+
+	return session, nil
+}
+
+// createSessionRequest is the body of POST /sessions.
+type createSessionRequest struct {
+	Title    string
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+// sessionTokenResponse is returned by POST /sessions and
+// POST /sessions/{id}/rotate: the data needed to render the QR code.
+type sessionTokenResponse struct {
+	SessionID uint      `json:"session_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createSessionHandler handles POST /sessions: lecturers create a new
+// session at the start of a lecture and get back the first session_token
+// to display.
+func createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAuthorization(w, r, conf.AllowedSessionAdminTokens) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body createSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("Malformed request body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	key, err := newSessionHMACKey()
+	if err != nil {
+		log.Printf("Failed to generate session HMAC key: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	session := Session{
+		Title:    body.Title,
+		StartsAt: body.StartsAt,
+		EndsAt:   body.EndsAt,
+		HMACKey:  key,
+	}
+	if err := db.Create(&session).Error; err != nil {
+		log.Printf("Failed to create session: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithSessionToken(w, session)
+}
+
+// rotateSessionHandler handles POST /sessions/{id}/rotate: issues a fresh
+// short-lived session_token for an existing session, so the QR code shown
+// on the projector can be rotated without changing the signing key.
+func rotateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkAuthorization(w, r, conf.AllowedSessionAdminTokens) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/rotate")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Malformed session id", http.StatusBadRequest)
+		return
+	}
+
+	var session Session
+	if err := db.First(&session, uint(id)).Error; err != nil {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+
+	respondWithSessionToken(w, session)
+}
+
+func respondWithSessionToken(w http.ResponseWriter, session Session) {
+	expiresAt := time.Now().Add(defaultSessionTokenValidity)
+	token, err := signSessionToken(session, expiresAt)
+	if err != nil {
+		log.Printf("Failed to sign session token: %s", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessionTokenResponse{
+		SessionID: session.ID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// openTestDB sets up an in-memory database with the schema the session
+// tests need, independent of the real on-disk db used by main().
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	testDB, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("could not open in-memory db: %s", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+	testDB.AutoMigrate(Session{})
+	return testDB
+}
+
+func mustCreateSession(t *testing.T, startsAt, endsAt time.Time) Session {
+	t.Helper()
+	key, err := newSessionHMACKey()
+	if err != nil {
+		t.Fatalf("newSessionHMACKey: %s", err)
+	}
+	session := Session{StartsAt: startsAt, EndsAt: endsAt, HMACKey: key}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatalf("could not create session: %s", err)
+	}
+	return session
+}
+
+func TestVerifySessionTokenValid(t *testing.T) {
+	db = openTestDB(t)
+	session := mustCreateSession(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	token, err := signSessionToken(session, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+
+	got, err := verifySessionToken(token)
+	if err != nil {
+		t.Fatalf("expected valid token, got error: %s", err)
+	}
+	if got.ID != session.ID {
+		t.Errorf("got session %d, want %d", got.ID, session.ID)
+	}
+}
+
+func TestVerifySessionTokenTampered(t *testing.T) {
+	db = openTestDB(t)
+	session := mustCreateSession(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	token, err := signSessionToken(session, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+
+	// Replace the first payload character. The payload starts with the
+	// decimal session id, whose base64url encoding never starts with "a",
+	// so this turns the id into a non-number and the token is rejected as
+	// malformed before its signature is checked.
+	tampered := "a" + token[1:]
+	if _, err := verifySessionToken(tampered); err == nil {
+		t.Fatal("expected tampered token to be rejected")
+	}
+}
+
+func TestVerifySessionTokenExpired(t *testing.T) {
+	db = openTestDB(t)
+	session := mustCreateSession(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	token, err := signSessionToken(session, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+
+	if _, err := verifySessionToken(token); err == nil {
+		t.Fatal("expected expired token to be rejected")
+	}
+}
+
+func TestVerifySessionTokenWrongSession(t *testing.T) {
+	db = openTestDB(t)
+	session := mustCreateSession(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	other := mustCreateSession(t, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	token, err := signSessionToken(session, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+	otherToken, err := signSessionToken(other, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+	if token == otherToken {
+		t.Fatal("expected distinct tokens for distinct sessions")
+	}
+
+	if _, err := verifySessionToken(otherToken); err != nil {
+		t.Fatalf("expected other's own token to verify, got: %s", err)
+	}
+
+	// Sign with session's key, then claim it belongs to other's id.
+	forger := other
+	forger.HMACKey = session.HMACKey
+	forged, err := signSessionToken(forger, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+	if _, err := verifySessionToken(forged); err == nil {
+		t.Fatal("expected a token signed with another session's key to be rejected")
+	}
+}
+
+func TestVerifySessionTokenBeforeStart(t *testing.T) {
+	db = openTestDB(t)
+	session := mustCreateSession(t, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+
+	token, err := signSessionToken(session, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+
+	if _, err := verifySessionToken(token); err == nil {
+		t.Fatal("expected token for a not-yet-started session to be rejected")
+	}
+}
+
+func TestVerifySessionTokenAfterEnd(t *testing.T) {
+	db = openTestDB(t)
+	session := mustCreateSession(t, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+
+	token, err := signSessionToken(session, time.Now().Add(defaultSessionTokenValidity))
+	if err != nil {
+		t.Fatalf("signSessionToken: %s", err)
+	}
+
+	if _, err := verifySessionToken(token); err == nil {
+		t.Fatal("expected token for an ended session to be rejected")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	texttemplate "text/template"
+	"time"
+)
+
+// langPattern restricts language codes before they ever reach a filesystem
+// path: plain ISO 639-1, optionally with a region subtag (eg. "en", "pt-BR").
+// Anything else (notably "../" path traversal) falls back to DefaultLang.
+var langPattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
+
+// TemplatesConf configures the confirmation mail's subject/body templates,
+// per language, so the same binary can serve multiple courses without
+// recompilation.
+type TemplatesConf struct {
+	// Dir holds the template files, named confirmation.<lang>.txt and,
+	// optionally, confirmation.<lang>.html. If Dir is empty, a built-in
+	// plain-text fallback is used for every language.
+	Dir string
+
+	// DefaultLang is used when a Request has no Lang, and as the
+	// fallback when a requested language has no templates.
+	DefaultLang string
+
+	// Subjects maps a language to its subject text/template string.
+	Subjects map[string]string
+
+	// CourseName and Instructors are made available to the templates, so
+	// the same binary can serve multiple courses.
+	CourseName  string
+	Instructors []string
+}
+
+// templateSession is the subset of Session made available to the
+// subject and body/html templates: it deliberately excludes HMACKey,
+// which signs session_tokens and must never end up in a mail sent to a
+// student.
+type templateSession struct {
+	ID       uint
+	Title    string
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+// confirmationTemplateData is the data made available to the subject and
+// body/html templates.
+type confirmationTemplateData struct {
+	Record
+	Session     *templateSession
+	CourseName  string
+	Instructors []string
+}
+
+const defaultConfirmationSubject = "Your attendance has been registered"
+
+const defaultConfirmationBody = "Dear {{.Name}},\n" +
+	"\n" +
+	"This email confirms that your presence{{if .Session}} at {{.Session.Title}}{{end}}\n" +
+	"has been registered at: {{.When}}\n" +
+	"\n" +
+	"With best regards,\n" +
+	"{{range $i, $instructor := .Instructors}}{{if $i}}, {{end}}{{$instructor}}{{end}}\n"
+
+// renderConfirmation picks the templates for record's language (falling
+// back to conf.Templates.DefaultLang, then to the built-in default) and
+// renders the confirmation subject, plain-text body and, if available,
+// HTML body.
+func renderConfirmation(record Record) (subject string, textBody string, htmlBody string, err error) {
+	lang := record.Lang
+	if lang == "" || !langPattern.MatchString(lang) {
+		lang = conf.Templates.DefaultLang
+	}
+
+	var session *templateSession
+	if record.SessionID != 0 {
+		var s Session
+		if err := db.First(&s, record.SessionID).Error; err == nil {
+			session = &templateSession{
+				ID:       s.ID,
+				Title:    s.Title,
+				StartsAt: s.StartsAt,
+				EndsAt:   s.EndsAt,
+			}
+		}
+	}
+
+	data := confirmationTemplateData{
+		Record:      record,
+		Session:     session,
+		CourseName:  conf.Templates.CourseName,
+		Instructors: conf.Templates.Instructors,
+	}
+
+	subject, err = renderSubject(lang, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	textBody, err = renderBodyFile(lang, "txt", defaultConfirmationBody, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// An HTML variant is optional: not every language needs one, and with
+	// no Templates.Dir configured we only ever send plain text.
+	htmlBody, err = renderHTMLFile(lang, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return subject, textBody, htmlBody, nil
+}
+
+func renderSubject(lang string, data confirmationTemplateData) (string, error) {
+	subjectText, ok := conf.Templates.Subjects[lang]
+	if !ok {
+		subjectText, ok = conf.Templates.Subjects[conf.Templates.DefaultLang]
+	}
+	if !ok {
+		subjectText = defaultConfirmationSubject
+	}
+
+	tpl, err := texttemplate.New("subject").Parse(subjectText)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func renderBodyFile(lang, ext, fallback string, data confirmationTemplateData) (string, error) {
+	text := fallback
+	if conf.Templates.Dir != "" {
+		path := confirmationTemplatePath(lang, ext)
+		if buf, err := ioutil.ReadFile(path); err == nil {
+			text = string(buf)
+		} else if !os.IsNotExist(err) {
+			return "", err
+		} else if lang != conf.Templates.DefaultLang {
+			return renderBodyFile(conf.Templates.DefaultLang, ext, fallback, data)
+		}
+	}
+
+	tpl, err := texttemplate.New("body." + ext).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func renderHTMLFile(lang string, data confirmationTemplateData) (string, error) {
+	if conf.Templates.Dir == "" {
+		return "", nil
+	}
+
+	path := confirmationTemplatePath(lang, "html")
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if lang != conf.Templates.DefaultLang {
+			return renderHTMLFile(conf.Templates.DefaultLang, data)
+		}
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	tpl, err := template.New("body.html").Parse(string(buf))
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func confirmationTemplatePath(lang, ext string) string {
+	return filepath.Join(conf.Templates.Dir, fmt.Sprintf("confirmation.%s.%s", lang, ext))
+}